@@ -0,0 +1,231 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+// Command performance-profile-creator derives the reserved/isolated CPU split for a node out of a
+// must-gather snapshot, picking the allocation strategy via --reserved-strategy.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/openshift-kni/performance-addon-operators/pkg/profilecreator"
+	"github.com/openshift-kni/performance-addon-operators/pkg/profilecreator/idset"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	strategyPackedSingleNUMA     = "packed-single-numa"
+	strategySplitEvenAcrossNUMA  = "split-even-across-numa"
+	strategyExplicitNUMANodes    = "explicit-numa-nodes"
+	strategyHousekeepingIsolated = "housekeeping-isolated"
+	strategyDeviceLocality       = "device-locality"
+)
+
+func main() {
+	mustGatherDirPath := flag.String("must-gather-dir-path", "", "Path to the must-gather directory")
+	nodeName := flag.String("node-name", "", "Name of the node to compute the reserved/isolated CPU split for")
+	mcpName := flag.String("mcp-name", "", "Name of a MachineConfigPool to validate topology uniformity for, instead of computing a reserved/isolated CPU split")
+	allowHeterogeneous := flag.Bool("allow-heterogeneous", false, "With --mcp-name: emit a report instead of failing when the pool's nodes have a non-uniform topology")
+	reservedCPUCount := flag.Int("reserved-cpu-count", 0, "Number of logical processors to reserve")
+	offlineCPUs := flag.String("offline-cpus", "", "CPU list (Linux syntax, e.g. 0-3,8) excluded from both the reserved and isolated pools")
+	reservedStrategy := flag.String("reserved-strategy", strategyPackedSingleNUMA,
+		fmt.Sprintf("Reserved CPU allocation strategy: one of %s, %s, %s, %s, %s",
+			strategyPackedSingleNUMA, strategySplitEvenAcrossNUMA, strategyExplicitNUMANodes, strategyHousekeepingIsolated, strategyDeviceLocality))
+	explicitNUMANodes := flag.String("explicit-numa-nodes", "", fmt.Sprintf("With --reserved-strategy=%s: comma separated node:count pairs, e.g. 0:4,2:2", strategyExplicitNUMANodes))
+	housekeepingAdditionalOnNode0 := flag.Int("housekeeping-additional-on-node0", 0, fmt.Sprintf("With --reserved-strategy=%s: additional logical processors to reserve on NUMA node 0", strategyHousekeepingIsolated))
+	preferredDevices := flag.String("preferred-devices", "", fmt.Sprintf("With --reserved-strategy=%s: comma separated PCI addresses the reserved set should be local to", strategyDeviceLocality))
+	flag.Parse()
+
+	if *mcpName != "" {
+		if err := validateMCPTopology(*mustGatherDirPath, *mcpName, *allowHeterogeneous); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := run(*mustGatherDirPath, *nodeName, *reservedCPUCount, *offlineCPUs, *reservedStrategy, *explicitNUMANodes, *housekeepingAdditionalOnNode0, *preferredDevices); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// validateMCPTopology diffs the topology of every node in the mcpName MachineConfigPool and prints
+// the resulting report as JSON. When the pool is non-uniform and allowHeterogeneous is false, it
+// still prints the report and then returns an error.
+func validateMCPTopology(mustGatherDirPath, mcpName string, allowHeterogeneous bool) error {
+	if mustGatherDirPath == "" {
+		return fmt.Errorf("--must-gather-dir-path is required")
+	}
+
+	nodes, err := profilecreator.GetNodeList(mustGatherDirPath)
+	if err != nil {
+		return fmt.Errorf("Error obtaining the node list: %v", err)
+	}
+	mcp, err := profilecreator.GetMCP(mustGatherDirPath, mcpName)
+	if err != nil {
+		return fmt.Errorf("Error obtaining MachineConfigPool %s: %v", mcpName, err)
+	}
+
+	report, validateErr := profilecreator.ValidateMCPTopologyUniformity(mustGatherDirPath, mcp, nodes, allowHeterogeneous)
+	if report != nil {
+		encoded, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("Error encoding topology uniformity report: %v", err)
+		}
+		fmt.Println(string(encoded))
+	}
+	return validateErr
+}
+
+func run(mustGatherDirPath, nodeName string, reservedCPUCount int, offlineCPUs, reservedStrategy, explicitNUMANodes string, housekeepingAdditionalOnNode0 int, preferredDevices string) error {
+	if mustGatherDirPath == "" || nodeName == "" {
+		return fmt.Errorf("--must-gather-dir-path and --node-name are required")
+	}
+
+	nodes, err := profilecreator.GetNodeList(mustGatherDirPath)
+	if err != nil {
+		return fmt.Errorf("Error obtaining the node list: %v", err)
+	}
+	node := findNode(nodes, nodeName)
+	if node == nil {
+		return fmt.Errorf("Node %s not found in the must-gather directory %s", nodeName, mustGatherDirPath)
+	}
+
+	ghwHandler, err := profilecreator.NewGHWHandler(mustGatherDirPath, node)
+	if err != nil {
+		return fmt.Errorf("Error creating the GHW handler for node %s: %v", nodeName, err)
+	}
+
+	offline, err := idset.Parse(offlineCPUs)
+	if err != nil {
+		return fmt.Errorf("Error parsing --offline-cpus: %v", err)
+	}
+	req := profilecreator.Request{ReservedCount: reservedCPUCount, OfflineCPUs: offline}
+
+	if reservedStrategy == strategyDeviceLocality {
+		devices := splitNonEmpty(preferredDevices)
+		reservedCPUs, isolatedCPUs, report, err := ghwHandler.GetReservedAndIsolatedCPUsByDeviceLocality(reservedCPUCount, devices, offline)
+		if err != nil {
+			return err
+		}
+		printResult(reservedCPUs, isolatedCPUs)
+		encoded, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("Error encoding device-locality report: %v", err)
+		}
+		fmt.Println(string(encoded))
+		return nil
+	}
+
+	allocator, err := buildAllocator(reservedStrategy, explicitNUMANodes, housekeepingAdditionalOnNode0)
+	if err != nil {
+		return err
+	}
+
+	topologyInfo, err := ghwHandler.SortedTopology()
+	if err != nil {
+		return fmt.Errorf("Error obtaining Topology Info from GHW snapshot: %v", err)
+	}
+	cpuInfo, err := ghwHandler.CPU()
+	if err != nil {
+		return fmt.Errorf("Error obtaining CPU Info from GHW snapshot: %v", err)
+	}
+
+	result, err := allocator.Allocate(topologyInfo, cpuInfo, req)
+	if err != nil {
+		return err
+	}
+	printResult(result.ReservedCPUs.String(), result.IsolatedCPUs.String())
+	return nil
+}
+
+func buildAllocator(reservedStrategy, explicitNUMANodes string, housekeepingAdditionalOnNode0 int) (profilecreator.ReservedAllocator, error) {
+	switch reservedStrategy {
+	case strategyPackedSingleNUMA:
+		return profilecreator.PackedSingleNUMA{}, nil
+	case strategySplitEvenAcrossNUMA:
+		return profilecreator.SplitEvenAcrossNUMA{}, nil
+	case strategyExplicitNUMANodes:
+		ids, perNode, err := parseExplicitNUMANodes(explicitNUMANodes)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing --explicit-numa-nodes: %v", err)
+		}
+		return profilecreator.ExplicitNUMANodes{IDs: ids, PerNode: perNode}, nil
+	case strategyHousekeepingIsolated:
+		return profilecreator.HousekeepingIsolated{AdditionalOnNode0: housekeepingAdditionalOnNode0}, nil
+	default:
+		return nil, fmt.Errorf("Unknown --reserved-strategy %q", reservedStrategy)
+	}
+}
+
+// parseExplicitNUMANodes parses a comma separated list of node:count pairs, e.g. "0:4,2:2", into
+// the ordered node id list and per-node count map ExplicitNUMANodes expects.
+func parseExplicitNUMANodes(s string) ([]int, map[int]int, error) {
+	ids := make([]int, 0)
+	perNode := make(map[int]int)
+	for _, pair := range splitNonEmpty(s) {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			return nil, nil, fmt.Errorf("invalid node:count pair %q", pair)
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid node id in %q: %v", pair, err)
+		}
+		count, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid count in %q: %v", pair, err)
+		}
+		ids = append(ids, id)
+		perNode[id] = count
+	}
+	if len(ids) == 0 {
+		return nil, nil, fmt.Errorf("--explicit-numa-nodes must list at least one node:count pair")
+	}
+	return ids, perNode, nil
+}
+
+func splitNonEmpty(s string) []string {
+	var result []string
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			result = append(result, part)
+		}
+	}
+	return result
+}
+
+func findNode(nodes []*v1.Node, name string) *v1.Node {
+	for _, node := range nodes {
+		if node.GetName() == name {
+			return node
+		}
+	}
+	return nil
+}
+
+func printResult(reservedCPUs, isolatedCPUs string) {
+	fmt.Printf("reservedCPUs=%s\n", reservedCPUs)
+	fmt.Printf("isolatedCPUs=%s\n", isolatedCPUs)
+}
@@ -0,0 +1,295 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package profilecreator
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/jaypipes/ghw/pkg/cpu"
+	"github.com/jaypipes/ghw/pkg/topology"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openshift-kni/performance-addon-operators/pkg/profilecreator/idset"
+)
+
+// Request carries the parameters a ReservedAllocator needs to compute a reserved/isolated CPU
+// split for a single node.
+type Request struct {
+	// ReservedCount is the number of logical processors to reserve.
+	ReservedCount int
+	// OfflineCPUs is excluded from both the reserved and isolated pools, e.g. to set aside cores
+	// for a kdump crash kernel or an irqbalance experiment.
+	OfflineCPUs *idset.Set
+	// PreferredDevices is the set of PCI addresses the caller wants the reserved set to be local
+	// to. No allocator in this package reads it: DeviceLocality ranks nodes by its own
+	// DistanceFromNode field instead.
+	PreferredDevices []string
+}
+
+// Result is the outcome of a ReservedAllocator.Allocate call.
+type Result struct {
+	ReservedCPUs *idset.Set
+	IsolatedCPUs *idset.Set
+}
+
+// ReservedAllocator computes a reserved/isolated CPU split for a node's topology. Implementations
+// must never split a physical core's HT siblings across the two pools.
+type ReservedAllocator interface {
+	Allocate(topologyInfo *topology.Info, cpuInfo *cpu.Info, req Request) (Result, error)
+}
+
+// PackedSingleNUMA reserves req.ReservedCount logical processors starting from the first NUMA
+// node, spilling onto the next node only once the current one is exhausted. This is the
+// historical default allocation used when splitReservedCPUsAcrossNUMA is false.
+type PackedSingleNUMA struct{}
+
+// Allocate implements ReservedAllocator.
+func (PackedSingleNUMA) Allocate(topologyInfo *topology.Info, cpuInfo *cpu.Info, req Request) (Result, error) {
+	return allocateToTargets(topologyInfo, req, cumulativeTargets(req.ReservedCount, 1))
+}
+
+// SplitEvenAcrossNUMA reserves req.ReservedCount logical processors split as evenly as possible
+// across every NUMA node, with any remainder distributed one CPU each to the first nodes. This is the
+// historical allocation used when splitReservedCPUsAcrossNUMA is true.
+type SplitEvenAcrossNUMA struct{}
+
+// Allocate implements ReservedAllocator.
+func (SplitEvenAcrossNUMA) Allocate(topologyInfo *topology.Info, cpuInfo *cpu.Info, req Request) (Result, error) {
+	return allocateToTargets(topologyInfo, req, cumulativeTargets(req.ReservedCount, len(topologyInfo.Nodes)))
+}
+
+// ExplicitNUMANodes reserves PerNode[id] logical processors from each NUMA node listed in IDs, in
+// the order given, e.g. PerNode {0: 4, 2: 2} reserves 4 logical processors on node 0 and 2 on node 2.
+type ExplicitNUMANodes struct {
+	IDs     []int
+	PerNode map[int]int
+}
+
+// Allocate implements ReservedAllocator.
+func (s ExplicitNUMANodes) Allocate(topologyInfo *topology.Info, cpuInfo *cpu.Info, req Request) (Result, error) {
+	htEnabled := topologySMTEnabled(topologyInfo)
+	nodesByID := make(map[int]*topology.Node, len(topologyInfo.Nodes))
+	for _, node := range topologyInfo.Nodes {
+		nodesByID[node.ID] = node
+	}
+
+	var totalCPUs, reservedCPUs []int
+	for _, node := range topologyInfo.Nodes {
+		totalCPUs = append(totalCPUs, withoutOffline(onlineCPUs(node), req.OfflineCPUs)...)
+	}
+	for _, id := range s.IDs {
+		node, ok := nodesByID[id]
+		if !ok {
+			return Result{}, fmt.Errorf("NUMA node %d not present in the topology", id)
+		}
+		want := s.PerNode[id]
+		if want%2 != 0 && htEnabled {
+			return Result{}, fmt.Errorf("Can't allocate an odd number of CPUs from NUMA node %d", id)
+		}
+		reservedCPUs = append(reservedCPUs, takeCoreGroupedCPUs(node.Cores, want, htEnabled, req.OfflineCPUs)...)
+	}
+
+	return buildResult(totalCPUs, reservedCPUs), nil
+}
+
+// HousekeepingIsolated reserves one full physical core per NUMA node, plus AdditionalOnNode0
+// logical processors on node 0, the common low-latency RAN housekeeping layout.
+type HousekeepingIsolated struct {
+	AdditionalOnNode0 int
+}
+
+// Allocate implements ReservedAllocator.
+func (s HousekeepingIsolated) Allocate(topologyInfo *topology.Info, cpuInfo *cpu.Info, req Request) (Result, error) {
+	htEnabled := topologySMTEnabled(topologyInfo)
+	var totalCPUs, reservedCPUs []int
+	for _, node := range topologyInfo.Nodes {
+		nodeCPUs := withoutOffline(onlineCPUs(node), req.OfflineCPUs)
+		totalCPUs = append(totalCPUs, nodeCPUs...)
+
+		want := 0
+		if len(node.Cores) > 0 {
+			want = len(withoutOffline(node.Cores[0].LogicalProcessors, req.OfflineCPUs))
+		}
+		if node.ID == 0 {
+			want += s.AdditionalOnNode0
+		}
+		reservedCPUs = append(reservedCPUs, takeCoreGroupedCPUs(node.Cores, want, htEnabled, req.OfflineCPUs)...)
+	}
+	return buildResult(totalCPUs, reservedCPUs), nil
+}
+
+// DeviceLocality pins the reserved set to the NUMA node(s) nearest the devices in
+// req.PreferredDevices, ranking nodes by DistanceFromNode (lowest distance first, ties broken by
+// node id) and draining cores from the nearest node(s) first. DistanceFromNode is normally built
+// from a GHWHandler's ghw.PCI/ghw.Topology snapshot by
+// GHWHandler.GetReservedAndIsolatedCPUsByDeviceLocality, which also resolves the PCI addresses it
+// is given to their local NUMA nodes. Allocate does not read req.PreferredDevices.
+type DeviceLocality struct {
+	DistanceFromNode map[int]int
+}
+
+// Allocate implements ReservedAllocator.
+func (s DeviceLocality) Allocate(topologyInfo *topology.Info, cpuInfo *cpu.Info, req Request) (Result, error) {
+	htEnabled := topologySMTEnabled(topologyInfo)
+	rankedNodes := make([]*topology.Node, len(topologyInfo.Nodes))
+	copy(rankedNodes, topologyInfo.Nodes)
+	sort.Slice(rankedNodes, func(i, j int) bool {
+		di, dj := s.DistanceFromNode[rankedNodes[i].ID], s.DistanceFromNode[rankedNodes[j].ID]
+		if di != dj {
+			return di < dj
+		}
+		return rankedNodes[i].ID < rankedNodes[j].ID
+	})
+
+	var totalCPUs, reservedCPUs []int
+	for _, node := range topologyInfo.Nodes {
+		totalCPUs = append(totalCPUs, withoutOffline(onlineCPUs(node), req.OfflineCPUs)...)
+	}
+	for _, node := range rankedNodes {
+		if len(reservedCPUs) >= req.ReservedCount {
+			break
+		}
+		want := req.ReservedCount - len(reservedCPUs)
+		reservedCPUs = append(reservedCPUs, takeCoreGroupedCPUs(node.Cores, want, htEnabled, req.OfflineCPUs)...)
+	}
+	if len(reservedCPUs) < req.ReservedCount {
+		return Result{}, fmt.Errorf("Not enough CPUs on the device-local NUMA node(s) to satisfy the requested reserved CPU count of %d", req.ReservedCount)
+	}
+
+	return buildResult(totalCPUs, reservedCPUs), nil
+}
+
+// cumulativeTargets computes the per-node limits of the allocation loop: with numaNodeNum nodes to
+// spread reservedCount over, node i (visited in topology order) should hold at most targets[i]
+// reserved CPUs cumulatively, with floor(reservedCount/numaNodeNum) per node and the remainder
+// distributed one CPU each to the first nodes. The last target is always reservedCount.
+func cumulativeTargets(reservedCount, numaNodeNum int) []int {
+	perNuma := reservedCount / numaNodeNum
+	remainder := reservedCount % numaNodeNum
+	if remainder != 0 {
+		log.Warnf("The reserved CPUs cannot be split equally across NUMA Nodes")
+	}
+	targets := make([]int, numaNodeNum)
+	max := 0
+	for i := 0; i < numaNodeNum; i++ {
+		max += perNuma
+		if i < remainder {
+			max++
+		}
+		targets[i] = max
+	}
+	return targets
+}
+
+// allocateToTargets drains reserved CPUs from each node in topology order up to the node's
+// cumulative target (targets[i] for a split allocation, or the single overall target repeated for
+// a packed one).
+func allocateToTargets(topologyInfo *topology.Info, req Request, targets []int) (Result, error) {
+	htEnabled := topologySMTEnabled(topologyInfo)
+	packed := len(targets) == 1
+
+	var totalCPUs, reservedCPUs []int
+	for numaID, node := range topologyInfo.Nodes {
+		nodeCPUs := withoutOffline(onlineCPUs(node), req.OfflineCPUs)
+		totalCPUs = append(totalCPUs, nodeCPUs...)
+
+		max := targets[0]
+		if !packed {
+			max = targets[numaID]
+		}
+		if max%2 != 0 && htEnabled {
+			return Result{}, fmt.Errorf("Can't allocatable odd number of CPUs from a NUMA Node")
+		}
+		if want := max - len(reservedCPUs); want > 0 {
+			reservedCPUs = append(reservedCPUs, takeCoreGroupedCPUs(node.Cores, want, htEnabled, req.OfflineCPUs)...)
+		}
+	}
+	return buildResult(totalCPUs, reservedCPUs), nil
+}
+
+// takeCoreGroupedCPUs drains up to want logical processors from cores, in order, skipping any id
+// present in offline. When htEnabled is true, whole cores are taken atomically so a core's
+// siblings are never split between the reserved and isolated pools; the result may then exceed
+// want by up to one core's width.
+func takeCoreGroupedCPUs(cores []*cpu.ProcessorCore, want int, htEnabled bool, offline *idset.Set) []int {
+	taken := make([]int, 0, want)
+	for _, core := range cores {
+		if len(taken) >= want {
+			break
+		}
+		lps := withoutOffline(core.LogicalProcessors, offline)
+		if htEnabled {
+			taken = append(taken, lps...)
+			continue
+		}
+		for _, lp := range lps {
+			if len(taken) >= want {
+				break
+			}
+			taken = append(taken, lp)
+		}
+	}
+	return taken
+}
+
+// onlineCPUs flattens every logical processor of every core on a NUMA node.
+func onlineCPUs(node *topology.Node) []int {
+	var cpus []int
+	for _, core := range node.Cores {
+		cpus = append(cpus, core.LogicalProcessors...)
+	}
+	return cpus
+}
+
+// withoutOffline returns lps with any id present in offline removed.
+func withoutOffline(lps []int, offline *idset.Set) []int {
+	if offline == nil || offline.Size() == 0 {
+		return lps
+	}
+	filtered := make([]int, 0, len(lps))
+	for _, lp := range lps {
+		if !offline.Contains(lp) {
+			filtered = append(filtered, lp)
+		}
+	}
+	return filtered
+}
+
+// topologySMTEnabled reports whether any physical core in the topology exposes more than one
+// logical processor.
+func topologySMTEnabled(topologyInfo *topology.Info) bool {
+	for _, node := range topologyInfo.Nodes {
+		for _, core := range node.Cores {
+			if len(core.LogicalProcessors) > 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// buildResult turns flat CPU id slices into a Result, computing the isolated set as the
+// difference between the total and reserved sets.
+func buildResult(totalCPUs, reservedCPUs []int) Result {
+	total := idset.New(totalCPUs...)
+	reserved := idset.New(reservedCPUs...)
+	return Result{
+		ReservedCPUs: reserved,
+		IsolatedCPUs: total.Difference(reserved),
+	}
+}
@@ -0,0 +1,135 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package profilecreator
+
+import (
+	"testing"
+
+	"github.com/jaypipes/ghw/pkg/cpu"
+	"github.com/jaypipes/ghw/pkg/topology"
+)
+
+// newNode builds a synthetic NUMA node out of per-core logical processor lists, e.g.
+// newNode(0, [][]int{{0, 1}, {2, 3}}) is a 2-core, SMT-2 node with logical processors 0-3.
+func newNode(id int, cores [][]int) *topology.Node {
+	node := &topology.Node{ID: id}
+	for _, lps := range cores {
+		node.Cores = append(node.Cores, &cpu.ProcessorCore{LogicalProcessors: lps})
+	}
+	return node
+}
+
+func smtTopology() *topology.Info {
+	return &topology.Info{Nodes: []*topology.Node{
+		newNode(0, [][]int{{0, 1}, {2, 3}}),
+		newNode(1, [][]int{{4, 5}, {6, 7}}),
+	}}
+}
+
+func TestPackedSingleNUMA_Allocate(t *testing.T) {
+	result, err := PackedSingleNUMA{}.Allocate(smtTopology(), nil, Request{ReservedCount: 4})
+	if err != nil {
+		t.Fatalf("Allocate returned error: %v", err)
+	}
+	if got, want := result.ReservedCPUs.String(), "0-3"; got != want {
+		t.Errorf("ReservedCPUs = %q, want %q", got, want)
+	}
+	if got, want := result.IsolatedCPUs.String(), "4-7"; got != want {
+		t.Errorf("IsolatedCPUs = %q, want %q", got, want)
+	}
+}
+
+func TestSplitEvenAcrossNUMA_Allocate(t *testing.T) {
+	result, err := SplitEvenAcrossNUMA{}.Allocate(smtTopology(), nil, Request{ReservedCount: 4})
+	if err != nil {
+		t.Fatalf("Allocate returned error: %v", err)
+	}
+	if got, want := result.ReservedCPUs.String(), "0-1,4-5"; got != want {
+		t.Errorf("ReservedCPUs = %q, want %q", got, want)
+	}
+	if got, want := result.IsolatedCPUs.String(), "2-3,6-7"; got != want {
+		t.Errorf("IsolatedCPUs = %q, want %q", got, want)
+	}
+}
+
+func TestExplicitNUMANodes_Allocate(t *testing.T) {
+	strategy := ExplicitNUMANodes{IDs: []int{1, 0}, PerNode: map[int]int{0: 2, 1: 4}}
+	result, err := strategy.Allocate(smtTopology(), nil, Request{})
+	if err != nil {
+		t.Fatalf("Allocate returned error: %v", err)
+	}
+	if got, want := result.ReservedCPUs.String(), "0-1,4-7"; got != want {
+		t.Errorf("ReservedCPUs = %q, want %q", got, want)
+	}
+}
+
+func TestExplicitNUMANodes_Allocate_UnknownNode(t *testing.T) {
+	strategy := ExplicitNUMANodes{IDs: []int{5}, PerNode: map[int]int{5: 2}}
+	if _, err := strategy.Allocate(smtTopology(), nil, Request{}); err == nil {
+		t.Error("expected an error for a NUMA node absent from the topology")
+	}
+}
+
+func TestHousekeepingIsolated_Allocate(t *testing.T) {
+	strategy := HousekeepingIsolated{AdditionalOnNode0: 2}
+	result, err := strategy.Allocate(smtTopology(), nil, Request{})
+	if err != nil {
+		t.Fatalf("Allocate returned error: %v", err)
+	}
+	// One full core (2 LPs) reserved per node, plus 2 additional LPs on node 0 — which, with only
+	// two 2-LP cores per node in this synthetic topology, consumes the rest of node 0, making the
+	// reserved set the contiguous run 0-5 (String() collapses it, rather than "0-3,4-5").
+	if got, want := result.ReservedCPUs.String(), "0-5"; got != want {
+		t.Errorf("ReservedCPUs = %q, want %q", got, want)
+	}
+	if got, want := result.IsolatedCPUs.String(), "6-7"; got != want {
+		t.Errorf("IsolatedCPUs = %q, want %q", got, want)
+	}
+}
+
+func TestDeviceLocality_Allocate(t *testing.T) {
+	strategy := DeviceLocality{DistanceFromNode: map[int]int{0: 20, 1: 10}}
+	result, err := strategy.Allocate(smtTopology(), nil, Request{ReservedCount: 2})
+	if err != nil {
+		t.Fatalf("Allocate returned error: %v", err)
+	}
+	// Node 1 is closer (distance 10 < 20), so the reserved set is drained from it first.
+	if got, want := result.ReservedCPUs.String(), "4-5"; got != want {
+		t.Errorf("ReservedCPUs = %q, want %q", got, want)
+	}
+}
+
+func TestDeviceLocality_Allocate_NotEnoughCPUs(t *testing.T) {
+	strategy := DeviceLocality{DistanceFromNode: map[int]int{0: 20, 1: 10}}
+	if _, err := strategy.Allocate(smtTopology(), nil, Request{ReservedCount: 99}); err == nil {
+		t.Error("expected an error when the topology cannot satisfy the requested reserved count")
+	}
+}
+
+func TestTakeCoreGroupedCPUs_KeepsSiblingsTogether(t *testing.T) {
+	cores := []*cpu.ProcessorCore{{LogicalProcessors: []int{0, 1}}, {LogicalProcessors: []int{2, 3}}}
+
+	// Asking for an odd count with SMT enabled must still return whole cores, never splitting a
+	// core's siblings across the reserved and isolated pools.
+	taken := takeCoreGroupedCPUs(cores, 1, true, nil)
+	if got, want := len(taken), 2; got != want {
+		t.Errorf("len(taken) = %d, want %d (a full core, not a split sibling pair)", got, want)
+	}
+	if taken[0] != 0 || taken[1] != 1 {
+		t.Errorf("taken = %v, want the whole first core [0 1]", taken)
+	}
+}
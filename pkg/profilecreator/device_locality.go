@@ -0,0 +1,152 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package profilecreator
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/jaypipes/ghw"
+	"github.com/jaypipes/ghw/pkg/pci"
+	"github.com/jaypipes/ghw/pkg/topology"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openshift-kni/performance-addon-operators/pkg/profilecreator/idset"
+)
+
+// DeviceNodeDistance reports, for a single requested PCI device, which NUMA node it is attached to
+// and how far every NUMA node on the system is from that node.
+type DeviceNodeDistance struct {
+	Address string
+	NodeID  int
+	// DistanceFromNode maps a candidate NUMA node id to its distance from this device's node.
+	DistanceFromNode map[int]int
+}
+
+// ReservedCPUSource reports which of the reserved CPUs were drained from a given NUMA node.
+type ReservedCPUSource struct {
+	NodeID int
+	CPUs   string
+}
+
+// DeviceLocalityReport is returned by GetReservedAndIsolatedCPUsByDeviceLocality alongside the
+// reserved/isolated cpuset strings, so the profile-creator CLI can explain why a given NUMA node
+// was chosen for the reserved set.
+type DeviceLocalityReport struct {
+	Devices []DeviceNodeDistance
+	Sources []ReservedCPUSource
+}
+
+// GetReservedAndIsolatedCPUsByDeviceLocality behaves like GetReservedAndIsolatedCPUs, but instead of
+// stacking the reserved set on NUMA node 0 or splitting it evenly across nodes, it pins the reserved
+// set to the NUMA node(s) nearest to the given PCI devices (e.g. the SR-IOV NIC powering a DPDK
+// workload), ranking candidate nodes by the NUMA distance matrix and draining cores from the
+// nearest node(s) first. offline is excluded from both the reserved and isolated pools, same as
+// Request.OfflineCPUs for every other ReservedAllocator.
+func (ghwHandler GHWHandler) GetReservedAndIsolatedCPUsByDeviceLocality(reservedCPUCount int, pciAddresses []string, offline *idset.Set) (string, string, *DeviceLocalityReport, error) {
+	if reservedCPUCount < 0 {
+		return "", "", nil, fmt.Errorf("Specified reserved CPU count is negative, please specify it correctly")
+	}
+	if len(pciAddresses) == 0 {
+		return "", "", nil, fmt.Errorf("At least one PCI device address must be specified for device-locality-aware allocation")
+	}
+
+	topologyInfo, err := ghwHandler.SortedTopology()
+	if err != nil {
+		return "", "", nil, fmt.Errorf("Error obtaining Topology Info from GHW snapshot: %v", err)
+	}
+	pciInfo, err := ghw.PCI(ghwHandler.snapShotOptions)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("Error obtaining PCI Info from GHW snapshot: %v", err)
+	}
+
+	report := &DeviceLocalityReport{}
+	aggregateDistance := make(map[int]int)
+	for _, address := range pciAddresses {
+		device := findPCIDevice(pciInfo, address)
+		if device == nil || device.Node == nil {
+			return "", "", nil, fmt.Errorf("Could not find a NUMA-local PCI device %q in the GHW snapshot", address)
+		}
+		deviceDistance := DeviceNodeDistance{
+			Address:          address,
+			NodeID:           device.Node.ID,
+			DistanceFromNode: make(map[int]int, len(topologyInfo.Nodes)),
+		}
+		for _, node := range topologyInfo.Nodes {
+			distance := distanceBetweenNodes(node, device.Node.ID)
+			deviceDistance.DistanceFromNode[node.ID] = distance
+			aggregateDistance[node.ID] += distance
+		}
+		report.Devices = append(report.Devices, deviceDistance)
+	}
+
+	allocator := DeviceLocality{DistanceFromNode: aggregateDistance}
+	result, err := allocator.Allocate(topologyInfo, nil, Request{ReservedCount: reservedCPUCount, OfflineCPUs: offline})
+	if err != nil {
+		return "", "", nil, err
+	}
+	report.Sources = reservedCPUsByNode(topologyInfo, result.ReservedCPUs)
+
+	log.Infof("device-local reservedCPUs: %v len(reservedCPUs): %d\n isolatedCPUs: %v len(isolatedCPUs): %d\n", result.ReservedCPUs.String(), result.ReservedCPUs.Size(), result.IsolatedCPUs.String(), result.IsolatedCPUs.Size())
+	return result.ReservedCPUs.String(), result.IsolatedCPUs.String(), report, nil
+}
+
+// findPCIDevice returns the PCI device matching the given address, or nil if it is not present in
+// the snapshot.
+func findPCIDevice(pciInfo *pci.Info, address string) *pci.Device {
+	for _, device := range pciInfo.Devices {
+		if device.Address == address {
+			return device
+		}
+	}
+	return nil
+}
+
+// reservedCPUsByNode groups reservedCPUs by the NUMA node each one belongs to, returning one
+// ReservedCPUSource per node that contributed CPUs, ordered by node id.
+func reservedCPUsByNode(topologyInfo *topology.Info, reservedCPUs *idset.Set) []ReservedCPUSource {
+	cpuToNode := make(map[int]int)
+	for _, node := range topologyInfo.Nodes {
+		for _, core := range node.Cores {
+			for _, lp := range core.LogicalProcessors {
+				cpuToNode[lp] = node.ID
+			}
+		}
+	}
+	groups := reservedCPUs.GroupBy(func(id int) int { return cpuToNode[id] })
+	nodeIDs := make([]int, 0, len(groups))
+	for nodeID := range groups {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	sort.Ints(nodeIDs)
+
+	sources := make([]ReservedCPUSource, 0, len(nodeIDs))
+	for _, nodeID := range nodeIDs {
+		sources = append(sources, ReservedCPUSource{NodeID: nodeID, CPUs: groups[nodeID].String()})
+	}
+	return sources
+}
+
+// distanceBetweenNodes returns the NUMA distance from node to the node identified by targetNodeID,
+// falling back to a large distance if the snapshot does not carry a distance matrix entry for it.
+func distanceBetweenNodes(node *topology.Node, targetNodeID int) int {
+	if targetNodeID >= 0 && targetNodeID < len(node.Distances) {
+		return node.Distances[targetNodeID]
+	}
+	log.Warnf("No NUMA distance recorded from node %d to node %d, assuming maximally distant", node.ID, targetNodeID)
+	return 1 << 30
+}
@@ -0,0 +1,200 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+// Package idset provides an immutable set of non-negative integer ids (CPU ids, NUMA node ids,
+// core ids, ...) along with the Linux CPU-list textual representation used throughout the
+// performance-addon-operators profile-creator. It exists so that allocation code can be written
+// against a small, stable, dependency-free API instead of reaching for cpuset.Builder from
+// k8s.io/kubernetes/pkg/kubelet/cm/cpuset, which pulls in the whole kubelet internals module.
+package idset
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Set is an immutable, sorted set of non-negative integer ids. The zero value is an empty set.
+type Set struct {
+	ids []int
+}
+
+// New returns a Set containing the given ids, deduplicated and sorted.
+func New(ids ...int) *Set {
+	return &Set{ids: dedupSorted(ids)}
+}
+
+// Parse builds a Set from the Linux CPU list syntax, e.g. "0-3,8,10-11".
+// An empty string parses to an empty set.
+func Parse(s string) (*Set, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return New(), nil
+	}
+	var ids []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		low, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid id list %q: %v", s, err)
+		}
+		high := low
+		if len(bounds) == 2 {
+			high, err = strconv.Atoi(bounds[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid id list %q: %v", s, err)
+			}
+		}
+		if high < low {
+			return nil, fmt.Errorf("invalid id list %q: range %q is descending", s, part)
+		}
+		for id := low; id <= high; id++ {
+			ids = append(ids, id)
+		}
+	}
+	return New(ids...), nil
+}
+
+// String renders the set using the Linux CPU list syntax, collapsing consecutive runs into
+// ranges, e.g. "0-3,8,10-11".
+func (s *Set) String() string {
+	if s.Size() == 0 {
+		return ""
+	}
+	var b strings.Builder
+	start := s.ids[0]
+	prev := s.ids[0]
+	writeRange := func(lo, hi int) {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		if lo == hi {
+			b.WriteString(strconv.Itoa(lo))
+		} else {
+			b.WriteString(strconv.Itoa(lo))
+			b.WriteByte('-')
+			b.WriteString(strconv.Itoa(hi))
+		}
+	}
+	for _, id := range s.ids[1:] {
+		if id == prev+1 {
+			prev = id
+			continue
+		}
+		writeRange(start, prev)
+		start, prev = id, id
+	}
+	writeRange(start, prev)
+	return b.String()
+}
+
+// List returns the sorted ids in the set. The returned slice must not be mutated.
+func (s *Set) List() []int {
+	return s.ids
+}
+
+// Size returns the number of ids in the set.
+func (s *Set) Size() int {
+	return len(s.ids)
+}
+
+// Contains reports whether id is a member of the set.
+func (s *Set) Contains(id int) bool {
+	i := sort.SearchInts(s.ids, id)
+	return i < len(s.ids) && s.ids[i] == id
+}
+
+// ContainsAll reports whether every given id is a member of the set.
+func (s *Set) ContainsAll(ids ...int) bool {
+	for _, id := range ids {
+		if !s.Contains(id) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsSubset reports whether every id in s is also a member of other.
+func (s *Set) IsSubset(other *Set) bool {
+	for _, id := range s.ids {
+		if !other.Contains(id) {
+			return false
+		}
+	}
+	return true
+}
+
+// Union returns a new Set containing every id in either s or other.
+func (s *Set) Union(other *Set) *Set {
+	return New(append(append([]int{}, s.ids...), other.ids...)...)
+}
+
+// Intersection returns a new Set containing the ids present in both s and other.
+func (s *Set) Intersection(other *Set) *Set {
+	var ids []int
+	for _, id := range s.ids {
+		if other.Contains(id) {
+			ids = append(ids, id)
+		}
+	}
+	return New(ids...)
+}
+
+// Difference returns a new Set containing the ids in s that are not in other.
+func (s *Set) Difference(other *Set) *Set {
+	var ids []int
+	for _, id := range s.ids {
+		if !other.Contains(id) {
+			ids = append(ids, id)
+		}
+	}
+	return New(ids...)
+}
+
+// GroupBy partitions the set using keyFunc, e.g. grouping CPU ids by core id, NUMA node id,
+// L3 cache id or socket id. The returned map's values are themselves immutable Sets.
+func (s *Set) GroupBy(keyFunc func(id int) int) map[int]*Set {
+	groups := make(map[int][]int)
+	for _, id := range s.ids {
+		key := keyFunc(id)
+		groups[key] = append(groups[key], id)
+	}
+	result := make(map[int]*Set, len(groups))
+	for key, ids := range groups {
+		result[key] = New(ids...)
+	}
+	return result
+}
+
+func dedupSorted(ids []int) []int {
+	if len(ids) == 0 {
+		return nil
+	}
+	sorted := append([]int{}, ids...)
+	sort.Ints(sorted)
+	deduped := sorted[:1]
+	for _, id := range sorted[1:] {
+		if id != deduped[len(deduped)-1] {
+			deduped = append(deduped, id)
+		}
+	}
+	return deduped
+}
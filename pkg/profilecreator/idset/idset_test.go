@@ -0,0 +1,110 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package idset
+
+import "testing"
+
+func TestParseString(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"empty", "", ""},
+		{"single", "8", "8"},
+		{"range", "0-3", "0-3"},
+		{"mixed", "0-3,8,10-11", "0-3,8,10-11"},
+		{"unsorted input collapses and sorts", "10,8,0-3,11", "0-3,8,10-11"},
+		{"duplicate ids collapse", "0-3,2,3", "0-3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, err := Parse(tt.in)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned error: %v", tt.in, err)
+			}
+			if got := set.String(); got != tt.want {
+				t.Errorf("Parse(%q).String() = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []string{"a-3", "3-1", "0-", "-1"}
+	for _, in := range tests {
+		if _, err := Parse(in); err == nil {
+			t.Errorf("Parse(%q) expected an error, got nil", in)
+		}
+	}
+}
+
+func TestContainsAndContainsAll(t *testing.T) {
+	set := New(0, 1, 2, 3)
+	if !set.Contains(2) {
+		t.Error("expected set to contain 2")
+	}
+	if set.Contains(4) {
+		t.Error("expected set not to contain 4")
+	}
+	if !set.ContainsAll(0, 2, 3) {
+		t.Error("expected set to contain all of 0, 2, 3")
+	}
+	if set.ContainsAll(0, 4) {
+		t.Error("expected set not to contain all of 0, 4")
+	}
+}
+
+func TestIsSubset(t *testing.T) {
+	superset := New(0, 1, 2, 3, 4)
+	if !New(1, 3).IsSubset(superset) {
+		t.Error("expected {1,3} to be a subset of {0,1,2,3,4}")
+	}
+	if New(1, 5).IsSubset(superset) {
+		t.Error("expected {1,5} not to be a subset of {0,1,2,3,4}")
+	}
+	if !New().IsSubset(superset) {
+		t.Error("expected the empty set to be a subset of any set")
+	}
+}
+
+func TestUnionIntersectionDifference(t *testing.T) {
+	a := New(0, 1, 2, 3)
+	b := New(2, 3, 4, 5)
+
+	if got, want := a.Union(b).String(), "0-5"; got != want {
+		t.Errorf("Union = %q, want %q", got, want)
+	}
+	if got, want := a.Intersection(b).String(), "2-3"; got != want {
+		t.Errorf("Intersection = %q, want %q", got, want)
+	}
+	if got, want := a.Difference(b).String(), "0-1"; got != want {
+		t.Errorf("Difference = %q, want %q", got, want)
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	set := New(0, 1, 2, 3, 4, 5)
+	groups := set.GroupBy(func(id int) int { return id % 2 })
+
+	if got, want := groups[0].String(), "0,2,4"; got != want {
+		t.Errorf("even group = %q, want %q", got, want)
+	}
+	if got, want := groups[1].String(), "1,3,5"; got != want {
+		t.Errorf("odd group = %q, want %q", got, want)
+	}
+}
@@ -17,12 +17,17 @@
 package profilecreator
 
 import (
+	"archive/tar"
+	"compress/gzip"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/jaypipes/ghw"
 	"github.com/jaypipes/ghw/pkg/cpu"
@@ -32,7 +37,6 @@ import (
 
 	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/component-helpers/scheduling/corev1"
-	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
 
 	machineconfigv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
 	v1 "k8s.io/api/core/v1"
@@ -56,6 +60,16 @@ const (
 	Nodes = "nodes"
 	// SysInfoFileName defines the name of the file where ghw snapshot is stored
 	SysInfoFileName = "sysinfo.tgz"
+	// smtActiveSysfsPath is the snapshot-relative path of the file reporting whether SMT is
+	// currently active on the host, as opposed to merely supported by the CPU.
+	smtActiveSysfsPath = "sys/devices/system/cpu/smt/active"
+	// smtControlSysfsPath is the snapshot-relative path of the file reporting whether SMT can be,
+	// and currently is, controlled on the host (e.g. "on", "off", "notsupported", "forceoff").
+	smtControlSysfsPath = "sys/devices/system/cpu/smt/control"
+	// nodeMeminfoSysfsPathFmt is the snapshot-relative path, parameterized by NUMA node id, of the
+	// file reporting that node's total physical memory. ghw.Memory only exposes a system-wide
+	// total, so per-node figures are read directly out of the snapshot instead.
+	nodeMeminfoSysfsPathFmt = "sys/devices/system/node/node%d/meminfo"
 )
 
 func init() {
@@ -208,20 +222,25 @@ func NewGHWHandler(mustGatherDirPath string, node *v1.Node) (*GHWHandler, error)
 	if err != nil {
 		return nil, fmt.Errorf("Error obtaining the node path %s: %v", nodeName, err)
 	}
-	_, err = os.Stat(path.Join(nodepath, nodeName, SysInfoFileName))
+	sysInfoPath := path.Join(nodepath, nodeName, SysInfoFileName)
+	_, err = os.Stat(sysInfoPath)
 	if err != nil {
 		return nil, fmt.Errorf("Error obtaining the path: %s for node %s: %v", nodeName, nodepath, err)
 	}
 	options := ghw.WithSnapshot(ghw.SnapshotOptions{
-		Path: path.Join(nodepath, nodeName, SysInfoFileName),
+		Path: sysInfoPath,
 	})
-	ghwHandler := &GHWHandler{snapShotOptions: options}
+	ghwHandler := &GHWHandler{snapShotOptions: options, sysInfoPath: sysInfoPath}
 	return ghwHandler, nil
 }
 
 // GHWHandler is a wrapper around ghw to get the API object
 type GHWHandler struct {
 	snapShotOptions *option.Option
+	// sysInfoPath is the path to the ghw sysinfo.tgz snapshot tarball itself, kept alongside
+	// snapShotOptions so that files the ghw API doesn't expose (e.g. the SMT sysfs files) can
+	// still be read directly out of the snapshot.
+	sysInfoPath string
 }
 
 // CPU returns a CPUInfo struct that contains information about the CPUs on the host system
@@ -251,7 +270,10 @@ func (ghwHandler GHWHandler) SortedTopology() (*topology.Info, error) {
 	return topologyInfo, nil
 }
 
-// GetReservedAndIsolatedCPUs returns Reserved and Isolated CPUs
+// GetReservedAndIsolatedCPUs returns Reserved and Isolated CPUs, using the PackedSingleNUMA
+// strategy by default, or SplitEvenAcrossNUMA when splitReservedCPUsAcrossNUMA is true. For other
+// allocation needs (an explicit per-node layout, housekeeping-plus-isolated, or device locality),
+// build the matching ReservedAllocator directly and call its Allocate method.
 func (ghwHandler GHWHandler) GetReservedAndIsolatedCPUs(reservedCPUCount int, splitReservedCPUsAcrossNUMA bool) (string, string, error) {
 	if reservedCPUCount < 0 {
 		return "", "", fmt.Errorf("Specified eservered CPU count is negative, please specify it correctly")
@@ -260,75 +282,123 @@ func (ghwHandler GHWHandler) GetReservedAndIsolatedCPUs(reservedCPUCount int, sp
 	if err != nil {
 		return "", "", fmt.Errorf("Error obtaining Topology Info from GHW snapshot: %v", err)
 	}
+	cpuInfo, err := ghwHandler.CPU()
+	if err != nil {
+		return "", "", fmt.Errorf("Error obtaining CPU Info from GHW snapshot: %v", err)
+	}
 
-	totalCPUSet := cpuset.NewBuilder()
-	reservedCPUSet := cpuset.NewBuilder()
-	var numaNodeNum int
+	var allocator ReservedAllocator = PackedSingleNUMA{}
 	if splitReservedCPUsAcrossNUMA {
-		numaNodeNum = len(topologyInfo.Nodes)
-	} else {
-		numaNodeNum = 1
+		allocator = SplitEvenAcrossNUMA{}
+	}
+	result, err := allocator.Allocate(topologyInfo, cpuInfo, Request{ReservedCount: reservedCPUCount})
+	if err != nil {
+		return "", "", err
 	}
+	log.Infof("reservedCPUs: %v len(reservedCPUs): %d\n isolatedCPUs: %v len(isolatedCPUs): %d\n", result.ReservedCPUs.String(), result.ReservedCPUs.Size(), result.IsolatedCPUs.String(), result.IsolatedCPUs.Size())
+	return result.ReservedCPUs.String(), result.IsolatedCPUs.String(), nil
+}
 
-	var max = 0
-	reservedPerNuma := reservedCPUCount / numaNodeNum
-	remainder := reservedCPUCount % numaNodeNum
-	if remainder != 0 {
-		log.Warnf("The reserved CPUs cannot be split equally across NUMA Nodes")
+// isHyperthreadingEnabled checks if hyperthreading is enabled on the system or not
+func (ghwHandler GHWHandler) isHyperthreadingEnabled() (bool, error) {
+	if enabled, ok := ghwHandler.smtActiveFromSysfs(); ok {
+		return enabled, nil
 	}
-	htEnabled, err := ghwHandler.isHyperthreadingEnabled()
+	// The must-gather snapshot predates the smt/active and smt/control sysfs capture: fall back to
+	// inferring SMT from the topology itself. If any physical core exposes more than one logical
+	// processor, HT/SMT must be turned on, regardless of what the "ht" capability flag on a
+	// processor merely advertises as supported.
+	topologyInfo, err := ghwHandler.SortedTopology()
 	if err != nil {
-		return "", "", fmt.Errorf("Error determining if Hyperthreading is enabled or not: %v", err)
-	}
-
-	//TODO: Make the allocation logic below more readable by using separate helper functions, one per allocation strategy
-	// (splitReservedCPUsAcrossNUMA=false/true -> two strategies) each one with its clear and nice allocation loop
-	for numaID, node := range topologyInfo.Nodes {
-		if splitReservedCPUsAcrossNUMA {
-			if remainder != 0 {
-				max = (numaID+1)*reservedPerNuma + (numaNodeNum - remainder)
-				remainder--
-			} else {
-				max = max + reservedPerNuma
-			}
-		} else {
-			max = reservedCPUCount
+		return false, fmt.Errorf("Error obtaining Topology Info from GHW snapshot: %v", err)
+	}
+	return topologySMTEnabled(topologyInfo), nil
+}
+
+// smtActiveFromSysfs reads /sys/devices/system/cpu/smt/{active,control} directly out of the
+// must-gather sysinfo.tgz snapshot, which is the standard way to query whether SMT is actually
+// turned on rather than merely supported. It returns ok=false when neither file is present in the
+// snapshot, so the caller can fall back to topology-based detection.
+func (ghwHandler GHWHandler) smtActiveFromSysfs() (enabled bool, ok bool) {
+	if active, err := readSnapshotFile(ghwHandler.sysInfoPath, smtActiveSysfsPath); err == nil {
+		return strings.TrimSpace(string(active)) == "1", true
+	}
+	if control, err := readSnapshotFile(ghwHandler.sysInfoPath, smtControlSysfsPath); err == nil {
+		return strings.TrimSpace(string(control)) == "on", true
+	}
+	return false, false
+}
+
+// readSnapshotFile extracts the contents of a single file from the ghw sysinfo.tgz snapshot
+// tarball, addressed by its path relative to the snapshot root, without unpacking the archive.
+func readSnapshotFile(sysInfoPath, relativePath string) ([]byte, error) {
+	if sysInfoPath == "" {
+		return nil, fmt.Errorf("no snapshot path available")
+	}
+	f, err := os.Open(sysInfoPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening snapshot %q: %v", sysInfoPath, err)
+	}
+	defer f.Close()
+
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading snapshot %q as gzip: %v", sysInfoPath, err)
+	}
+	defer gzr.Close()
+
+	tr := tar.NewReader(gzr)
+	relativePath = strings.TrimPrefix(relativePath, "./")
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
 		}
-		if max%2 != 0 && htEnabled {
-			return "", "", fmt.Errorf("Can't allocatable odd number of CPUs from a NUMA Node")
+		if err != nil {
+			return nil, fmt.Errorf("Error reading snapshot %q: %v", sysInfoPath, err)
 		}
-		for _, processorCores := range node.Cores {
-			for _, core := range processorCores.LogicalProcessors {
-				totalCPUSet.Add(core)
-				if reservedCPUSet.Result().Size() < max {
-					reservedCPUSet.Add(core)
-				}
-			}
+		if strings.TrimPrefix(header.Name, "./") == relativePath {
+			return ioutil.ReadAll(tr)
 		}
 	}
-	isolatedCPUSet := totalCPUSet.Result().Difference(reservedCPUSet.Result())
-	log.Infof("reservedCPUs: %v len(reservedCPUs): %d\n isolatedCPUs: %v len(isolatedCPUs): %d\n", reservedCPUSet.Result().String(), reservedCPUSet.Result().Size(), isolatedCPUSet.String(), isolatedCPUSet.Size())
-	return reservedCPUSet.Result().String(), isolatedCPUSet.String(), nil
-
+	return nil, fmt.Errorf("%s not present in snapshot %q", relativePath, sysInfoPath)
 }
 
-// isHyperthreadingEnabled checks if hyperthreading is enabled on the system or not
-func (ghwHandler GHWHandler) isHyperthreadingEnabled() (bool, error) {
-	cpuInfo, err := ghwHandler.CPU()
+// nodeMemoryBytes returns the total physical memory attached to a single NUMA node, read directly
+// out of the snapshot's per-node meminfo sysfs file (e.g. "Node 0 MemTotal:  32876544 kB").
+func (ghwHandler GHWHandler) nodeMemoryBytes(nodeID int) (uint64, error) {
+	contents, err := readSnapshotFile(ghwHandler.sysInfoPath, fmt.Sprintf(nodeMeminfoSysfsPathFmt, nodeID))
 	if err != nil {
-		return false, fmt.Errorf("Error obtaining CPU Info from GHW snapshot: %v", err)
+		return 0, err
 	}
-	// Since there is no way to disable flags per-processor (not system wide) we check the flags of the first available processor.
-	// A following implementation will leverage the /sys/devices/system/cpu/smt/active file which is the "standard" way to query HT.
-	return contains(cpuInfo.Processors[0].Capabilities, "ht"), nil
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 4 && fields[2] == "MemTotal:" {
+			kB, err := strconv.ParseUint(fields[3], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("Error parsing MemTotal for NUMA node %d: %v", nodeID, err)
+			}
+			return kB * 1024, nil
+		}
+	}
+	return 0, fmt.Errorf("MemTotal not present in the node %d meminfo snapshot", nodeID)
 }
 
-// contains checks if a string is present in a slice
-func contains(s []string, str string) bool {
-	for _, v := range s {
-		if v == str {
-			return true
+// SiblingGroups returns the logical processors of every physical core on the system, grouped by
+// core, in the same node/core order as SortedTopology. It lets the reserved-CPU UX and tests
+// verify that a core's siblings were not split across the reserved and isolated pools.
+func (ghwHandler GHWHandler) SiblingGroups() ([][]int, error) {
+	topologyInfo, err := ghwHandler.SortedTopology()
+	if err != nil {
+		return nil, fmt.Errorf("Error obtaining Topology Info from GHW snapshot: %v", err)
+	}
+	groups := make([][]int, 0)
+	for _, node := range topologyInfo.Nodes {
+		for _, core := range node.Cores {
+			siblings := make([]int, len(core.LogicalProcessors))
+			copy(siblings, core.LogicalProcessors)
+			groups = append(groups, siblings)
 		}
 	}
-	return false
+	return groups, nil
 }
@@ -0,0 +1,180 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package profilecreator
+
+import (
+	"fmt"
+
+	machineconfigv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/openshift-kni/performance-addon-operators/pkg/profilecreator/idset"
+)
+
+// TopologyMismatch describes a single field on which a node's topology diverges from the
+// reference node used to build a PerformanceProfile.
+type TopologyMismatch struct {
+	Field         string `json:"field"`
+	ReferenceNode string `json:"referenceNode"`
+	Expected      string `json:"expected"`
+	Node          string `json:"node"`
+	Actual        string `json:"actual"`
+}
+
+// TopologyUniformityReport is the result of diffing the topology of every node matched by an
+// MCP's node selector against a single reference node.
+type TopologyUniformityReport struct {
+	Uniform bool `json:"uniform"`
+	// Mismatches is empty when Uniform is true.
+	Mismatches []TopologyMismatch `json:"mismatches,omitempty"`
+	// SuggestedReservedCPUs is the largest CPU set present, at the same logical CPU ids, on every
+	// matched node. It is only populated when the nodes diverge, as a safe starting point for a
+	// reserved set that is valid cluster-wide.
+	SuggestedReservedCPUs string `json:"suggestedReservedCPUs,omitempty"`
+}
+
+// nodeTopologySignature is the subset of a node's topology that must match across every node in
+// an MCP for a single PerformanceProfile to apply uniformly to the pool.
+type nodeTopologySignature struct {
+	nodeName           string
+	numaNodeCount      int
+	coresPerNode       []int
+	threadsPerCore     int
+	onlineCPUs         *idset.Set
+	memoryBytesPerNode []uint64
+	smtEnabled         bool
+}
+
+// ValidateMCPTopologyUniformity runs a GHWHandler against every node matched by mcp's node
+// selector and diffs their topologies: NUMA node count, cores-per-node, logical-processors-per-core,
+// online CPU mask, total memory per NUMA node and whether SMT is enabled. Today the profile-creator
+// silently picks one node's snapshot as representative of the whole pool; in real clusters
+// (spare-part CPU swaps, mixed SKU expansions) that produces a profile that misconfigures part of
+// the pool. Unless allowHeterogeneous is true, a non-uniform pool is reported as an error instead
+// of a profile the caller might otherwise emit unchecked.
+func ValidateMCPTopologyUniformity(mustGatherDirPath string, mcp *machineconfigv1.MachineConfigPool, nodes []*v1.Node, allowHeterogeneous bool) (*TopologyUniformityReport, error) {
+	matchedNodes, err := GetMatchedNodes(nodes, mcp.Spec.NodeSelector)
+	if err != nil {
+		return nil, fmt.Errorf("Error obtaining nodes matched by MachineConfigPool %s: %v", mcp.GetName(), err)
+	}
+	if len(matchedNodes) == 0 {
+		return nil, fmt.Errorf("No nodes matched by MachineConfigPool %s", mcp.GetName())
+	}
+
+	signatures := make([]*nodeTopologySignature, 0, len(matchedNodes))
+	for _, node := range matchedNodes {
+		signature, err := nodeTopologySignatureFor(mustGatherDirPath, node)
+		if err != nil {
+			return nil, fmt.Errorf("Error building topology signature for node %s: %v", node.GetName(), err)
+		}
+		signatures = append(signatures, signature)
+	}
+
+	reference := signatures[0]
+	report := &TopologyUniformityReport{Uniform: true}
+	commonCPUs := reference.onlineCPUs
+	for _, signature := range signatures[1:] {
+		commonCPUs = commonCPUs.Intersection(signature.onlineCPUs)
+		report.Mismatches = append(report.Mismatches, diffSignatures(reference, signature)...)
+	}
+
+	if len(report.Mismatches) > 0 {
+		report.Uniform = false
+		report.SuggestedReservedCPUs = commonCPUs.String()
+		if !allowHeterogeneous {
+			return report, fmt.Errorf("Nodes matched by MachineConfigPool %s do not have a uniform topology; refusing to emit a single PerformanceProfile for the pool unless --allow-heterogeneous is passed", mcp.GetName())
+		}
+	}
+	return report, nil
+}
+
+func nodeTopologySignatureFor(mustGatherDirPath string, node *v1.Node) (*nodeTopologySignature, error) {
+	ghwHandler, err := NewGHWHandler(mustGatherDirPath, node)
+	if err != nil {
+		return nil, err
+	}
+	topologyInfo, err := ghwHandler.SortedTopology()
+	if err != nil {
+		return nil, fmt.Errorf("Error obtaining Topology Info from GHW snapshot: %v", err)
+	}
+	smtEnabled, err := ghwHandler.isHyperthreadingEnabled()
+	if err != nil {
+		return nil, fmt.Errorf("Error determining if Hyperthreading is enabled or not: %v", err)
+	}
+
+	coresPerNode := make([]int, len(topologyInfo.Nodes))
+	memoryBytesPerNode := make([]uint64, len(topologyInfo.Nodes))
+	var onlineCPUs []int
+	threadsPerCore := 0
+	for i, numaNode := range topologyInfo.Nodes {
+		coresPerNode[i] = len(numaNode.Cores)
+		nodeMemoryBytes, err := ghwHandler.nodeMemoryBytes(numaNode.ID)
+		if err != nil {
+			return nil, fmt.Errorf("Error obtaining memory info for NUMA node %d: %v", numaNode.ID, err)
+		}
+		memoryBytesPerNode[i] = nodeMemoryBytes
+		for _, core := range numaNode.Cores {
+			onlineCPUs = append(onlineCPUs, core.LogicalProcessors...)
+			if len(core.LogicalProcessors) > threadsPerCore {
+				threadsPerCore = len(core.LogicalProcessors)
+			}
+		}
+	}
+
+	return &nodeTopologySignature{
+		nodeName:           node.GetName(),
+		numaNodeCount:      len(topologyInfo.Nodes),
+		coresPerNode:       coresPerNode,
+		threadsPerCore:     threadsPerCore,
+		onlineCPUs:         idset.New(onlineCPUs...),
+		memoryBytesPerNode: memoryBytesPerNode,
+		smtEnabled:         smtEnabled,
+	}, nil
+}
+
+func diffSignatures(reference, node *nodeTopologySignature) []TopologyMismatch {
+	mismatches := make([]TopologyMismatch, 0)
+	add := func(field, expected, actual string) {
+		mismatches = append(mismatches, TopologyMismatch{
+			Field:         field,
+			ReferenceNode: reference.nodeName,
+			Expected:      expected,
+			Node:          node.nodeName,
+			Actual:        actual,
+		})
+	}
+
+	if reference.numaNodeCount != node.numaNodeCount {
+		add("numaNodeCount", fmt.Sprintf("%d", reference.numaNodeCount), fmt.Sprintf("%d", node.numaNodeCount))
+	}
+	if fmt.Sprintf("%v", reference.coresPerNode) != fmt.Sprintf("%v", node.coresPerNode) {
+		add("coresPerNode", fmt.Sprintf("%v", reference.coresPerNode), fmt.Sprintf("%v", node.coresPerNode))
+	}
+	if reference.threadsPerCore != node.threadsPerCore {
+		add("threadsPerCore", fmt.Sprintf("%d", reference.threadsPerCore), fmt.Sprintf("%d", node.threadsPerCore))
+	}
+	if reference.onlineCPUs.String() != node.onlineCPUs.String() {
+		add("onlineCPUs", reference.onlineCPUs.String(), node.onlineCPUs.String())
+	}
+	if fmt.Sprintf("%v", reference.memoryBytesPerNode) != fmt.Sprintf("%v", node.memoryBytesPerNode) {
+		add("memoryBytesPerNode", fmt.Sprintf("%v", reference.memoryBytesPerNode), fmt.Sprintf("%v", node.memoryBytesPerNode))
+	}
+	if reference.smtEnabled != node.smtEnabled {
+		add("smtEnabled", fmt.Sprintf("%t", reference.smtEnabled), fmt.Sprintf("%t", node.smtEnabled))
+	}
+	return mismatches
+}
@@ -0,0 +1,92 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package profilecreator
+
+import (
+	"testing"
+
+	"github.com/openshift-kni/performance-addon-operators/pkg/profilecreator/idset"
+)
+
+func referenceSignature() *nodeTopologySignature {
+	return &nodeTopologySignature{
+		nodeName:           "node-a",
+		numaNodeCount:      2,
+		coresPerNode:       []int{2, 2},
+		threadsPerCore:     2,
+		onlineCPUs:         idset.New(0, 1, 2, 3, 4, 5, 6, 7),
+		memoryBytesPerNode: []uint64{32 << 30, 32 << 30},
+		smtEnabled:         true,
+	}
+}
+
+func TestDiffSignatures_Uniform(t *testing.T) {
+	reference := referenceSignature()
+	other := referenceSignature()
+	other.nodeName = "node-b"
+
+	if mismatches := diffSignatures(reference, other); len(mismatches) != 0 {
+		t.Errorf("expected no mismatches between identical signatures, got %v", mismatches)
+	}
+}
+
+func TestDiffSignatures_MemoryPerNodeMismatch(t *testing.T) {
+	reference := referenceSignature()
+	other := referenceSignature()
+	other.nodeName = "node-b"
+	// Same total memory across the node, but distributed differently per NUMA node: a system-wide
+	// total comparison would miss this, a per-node comparison must not.
+	other.memoryBytesPerNode = []uint64{48 << 30, 16 << 30}
+
+	mismatches := diffSignatures(reference, other)
+	if len(mismatches) != 1 || mismatches[0].Field != "memoryBytesPerNode" {
+		t.Fatalf("expected a single memoryBytesPerNode mismatch, got %v", mismatches)
+	}
+}
+
+func TestDiffSignatures_MultipleFields(t *testing.T) {
+	reference := referenceSignature()
+	other := referenceSignature()
+	other.nodeName = "node-b"
+	other.numaNodeCount = 1
+	other.coresPerNode = []int{4}
+	other.threadsPerCore = 1
+	other.onlineCPUs = idset.New(0, 1, 2, 3)
+	other.memoryBytesPerNode = []uint64{64 << 30}
+	other.smtEnabled = false
+
+	mismatches := diffSignatures(reference, other)
+	wantFields := map[string]bool{
+		"numaNodeCount":      true,
+		"coresPerNode":       true,
+		"threadsPerCore":     true,
+		"onlineCPUs":         true,
+		"memoryBytesPerNode": true,
+		"smtEnabled":         true,
+	}
+	if len(mismatches) != len(wantFields) {
+		t.Fatalf("got %d mismatches, want %d: %v", len(mismatches), len(wantFields), mismatches)
+	}
+	for _, mismatch := range mismatches {
+		if !wantFields[mismatch.Field] {
+			t.Errorf("unexpected mismatch field %q", mismatch.Field)
+		}
+		if mismatch.ReferenceNode != "node-a" || mismatch.Node != "node-b" {
+			t.Errorf("mismatch %+v has unexpected node names", mismatch)
+		}
+	}
+}